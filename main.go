@@ -3,43 +3,228 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log/syslog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"testing"
 	"time"
 
 	"github.com/amir/raidman"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	mysql "github.com/siddontang/go-mysql/client"
+	gomysql "github.com/siddontang/go-mysql/mysql"
 	"gopkg.in/inconshreveable/log15.v2"
 	"gopkg.in/tomb.v2"
 )
 
+// mysqlTarget describes a single MySQL/MariaDB instance to monitor, along
+// with the connection settings used to reach it. One goroutine is spawned
+// per target.
+type mysqlTarget struct {
+	Label    string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Database string
+
+	TLS           bool
+	SSLCA         string
+	SSLCert       string
+	SSLKey        string
+	TLSSkipVerify bool
+	TLSServerName string
+
+	tlsConfig *tls.Config
+
+	// Heartbeat-table based lag measurement (pt-heartbeat compatible),
+	// used in place of Seconds_Behind_Master when configured.
+	HeartbeatTable          string
+	HeartbeatServerIDColumn string
+	HeartbeatTSColumn       string
+	HeartbeatMasterServerID string
+
+	// Questions/sec is derived from successive polls of the cumulative
+	// Questions counter, so we keep the previous observation per target.
+	haveQuestions   bool
+	prevQuestions   float64
+	prevQuestionsAt time.Time
+}
+
+// addr returns the host:port pair this target connects to.
+func (m *mysqlTarget) addr() string {
+	return net.JoinHostPort(m.Host, m.Port)
+}
+
+// label returns the name used to identify this target, falling back to its
+// address when no explicit `mysql_label` was configured.
+func (m *mysqlTarget) label() string {
+	if m.Label != "" {
+		return m.Label
+	}
+
+	return m.addr()
+}
+
+// questionsRate computes the Questions/sec rate since the previous poll of
+// this target. ok is false on the first observation, when no rate decrease
+// is caused by the counter having been reset (e.g. after a restart).
+func (m *mysqlTarget) questionsRate(questions float64, now time.Time) (rate float64, ok bool) {
+	defer func() {
+		m.prevQuestions = questions
+		m.prevQuestionsAt = now
+		m.haveQuestions = true
+	}()
+
+	if !m.haveQuestions || questions < m.prevQuestions {
+		return 0, false
+	}
+
+	elapsed := now.Sub(m.prevQuestionsAt).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+
+	return (questions - m.prevQuestions) / elapsed, true
+}
+
+// threshold holds the configured warn/crit levels for a status metric.
+type threshold struct {
+	Warn *float64
+	Crit *float64
+}
+
+// statusThresholds holds the warn/crit thresholds configured via
+// `threshold_<metric>_warn` / `threshold_<metric>_crit`, applied to the
+// `mysql/status/*` metrics gathered from every target.
+var statusThresholds = map[string]*threshold{}
+
+// setThreshold parses a `threshold_<metric>_warn` or `threshold_<metric>_crit`
+// configuration key and records its value in statusThresholds.
+func setThreshold(key, value string) error {
+	var (
+		metric string
+		isWarn bool
+	)
+
+	switch {
+	case strings.HasSuffix(key, "_warn"):
+		metric, isWarn = strings.TrimSuffix(strings.TrimPrefix(key, "threshold_"), "_warn"), true
+	case strings.HasSuffix(key, "_crit"):
+		metric, isWarn = strings.TrimSuffix(strings.TrimPrefix(key, "threshold_"), "_crit"), false
+	default:
+		return fmt.Errorf("malformed threshold setting %q, expected a _warn or _crit suffix", key)
+	}
+
+	level, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %q for setting %q", value, key)
+	}
+
+	t, ok := statusThresholds[metric]
+	if !ok {
+		t = &threshold{}
+		statusThresholds[metric] = t
+	}
+	if isWarn {
+		t.Warn = &level
+	} else {
+		t.Crit = &level
+	}
+
+	return nil
+}
+
+// thresholdState evaluates value against the configured thresholds for
+// metric, defaulting to "ok" when none were configured.
+func thresholdState(metric string, value float64) string {
+	t, ok := statusThresholds[metric]
+	if !ok {
+		return "ok"
+	}
+
+	if t.Crit != nil && value >= *t.Crit {
+		return "critical"
+	}
+	if t.Warn != nil && value >= *t.Warn {
+		return "warning"
+	}
+
+	return "ok"
+}
+
 var (
 	mysqlHost     = "localhost"
 	mysqlPort     = "3306"
 	mysqlUser     = "root"
 	mysqlPassword = "root"
 	mysqlDatabase = ""
-	riemannHost   = "localhost"
-	riemannPort   = "5555"
-	riemannTTL    float32
-	riemannTags   []string
-	hostname      string
-	interval      = time.Second * 30
-	delay         = 2.0
+
+	mysqlTLS           bool
+	mysqlSSLCA         string
+	mysqlSSLCert       string
+	mysqlSSLKey        string
+	mysqlTLSSkipVerify bool
+	mysqlTLSServerName string
+
+	mysqlHeartbeatTable          string
+	mysqlHeartbeatServerIDColumn string
+	mysqlHeartbeatTSColumn       string
+	mysqlHeartbeatMasterServerID string
+
+	mysqlTargets []*mysqlTarget
+
+	riemannHost = "localhost"
+	riemannPort = "5555"
+	riemannTTL  float32
+	riemannTags []string
+	hostname    string
+	interval    = time.Second * 30
+	delay       = 2.0
+
+	outputs              = []string{"riemann"}
+	prometheusListenAddr string
+	graphiteAddr         string
+
+	shutdownTimeout = 10 * time.Second
 
 	configFile string
 	debug      bool
 	log        log15.Logger
 )
 
+// startupHooks are run once, after signal handlers are installed but before
+// the first tick, so that subsystems built up while constructing emitters
+// (e.g. the Prometheus HTTP server) can start without racing shutdown.
+var startupHooks []func()
+
+// onStartup registers a hook to be run by main once signal handlers are
+// installed and before polling begins.
+func onStartup(hook func()) {
+	startupHooks = append(startupHooks, hook)
+}
+
 func init() {
+	// Under `go test`, flag.Parse() would choke on the testing package's
+	// own flags (which aren't registered yet at this point) and there is
+	// no config file or syslog to set up anyway, so skip straight past.
+	if testing.Testing() {
+		return
+	}
+
 	var (
 		h   log15.Handler
 		err error
@@ -69,11 +254,28 @@ func init() {
 		}
 	}
 
+	for _, target := range mysqlTargets {
+		if !target.TLS {
+			continue
+		}
+
+		if target.tlsConfig, err = buildTLSConfig(target); err != nil {
+			dieOnError(fmt.Sprintf("unable to build TLS configuration for target %q: %s", target.label(), err))
+		}
+	}
+
 	riemannTTL = float32(interval + time.Duration(delay))
 }
 
+// loadConfig parses the configuration file at path. Top-level `mysql_*`
+// settings configure a single default target; one or more `[mysql]` blocks
+// may be used to describe additional targets, each inheriting the top-level
+// settings as defaults and overriding what it needs to.
 func loadConfig(path string) error {
-	var k, v string
+	var (
+		k, v    string
+		current *mysqlTarget
+	)
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -84,7 +286,31 @@ func loadConfig(path string) error {
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if len(line) == 0 || strings.HasPrefix(strings.TrimSpace(line), "#") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if trimmed == "[mysql]" {
+			current = &mysqlTarget{
+				Host:          mysqlHost,
+				Port:          mysqlPort,
+				User:          mysqlUser,
+				Password:      mysqlPassword,
+				Database:      mysqlDatabase,
+				TLS:           mysqlTLS,
+				SSLCA:         mysqlSSLCA,
+				SSLCert:       mysqlSSLCert,
+				SSLKey:        mysqlSSLKey,
+				TLSSkipVerify: mysqlTLSSkipVerify,
+				TLSServerName: mysqlTLSServerName,
+
+				HeartbeatTable:          mysqlHeartbeatTable,
+				HeartbeatServerIDColumn: mysqlHeartbeatServerIDColumn,
+				HeartbeatTSColumn:       mysqlHeartbeatTSColumn,
+				HeartbeatMasterServerID: mysqlHeartbeatMasterServerID,
+			}
+			mysqlTargets = append(mysqlTargets, current)
 			continue
 		}
 
@@ -98,21 +324,132 @@ func loadConfig(path string) error {
 			"key", k,
 			"value", v)
 
+		if strings.HasPrefix(k, "threshold_") {
+			if err := setThreshold(k, v); err != nil {
+				return err
+			}
+			continue
+		}
+
 		switch k {
+		case "mysql_label":
+			if current == nil {
+				return fmt.Errorf("`mysql_label` is only valid inside a [mysql] block")
+			}
+			current.Label = v
+
 		case "mysql_host":
-			mysqlHost = v
+			if current != nil {
+				current.Host = v
+			} else {
+				mysqlHost = v
+			}
 
 		case "mysql_port":
-			mysqlPort = v
+			if current != nil {
+				current.Port = v
+			} else {
+				mysqlPort = v
+			}
 
 		case "mysql_user":
-			mysqlUser = v
+			if current != nil {
+				current.User = v
+			} else {
+				mysqlUser = v
+			}
 
 		case "mysql_password":
-			mysqlPassword = v
+			if current != nil {
+				current.Password = v
+			} else {
+				mysqlPassword = v
+			}
 
 		case "mysql_database":
-			mysqlDatabase = v
+			if current != nil {
+				current.Database = v
+			} else {
+				mysqlDatabase = v
+			}
+
+		case "mysql_tls":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for setting `mysql_tls`", v)
+			}
+			if current != nil {
+				current.TLS = b
+			} else {
+				mysqlTLS = b
+			}
+
+		case "mysql_ssl_ca":
+			if current != nil {
+				current.SSLCA = v
+			} else {
+				mysqlSSLCA = v
+			}
+
+		case "mysql_ssl_cert":
+			if current != nil {
+				current.SSLCert = v
+			} else {
+				mysqlSSLCert = v
+			}
+
+		case "mysql_ssl_key":
+			if current != nil {
+				current.SSLKey = v
+			} else {
+				mysqlSSLKey = v
+			}
+
+		case "mysql_tls_skip_verify":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for setting `mysql_tls_skip_verify`", v)
+			}
+			if current != nil {
+				current.TLSSkipVerify = b
+			} else {
+				mysqlTLSSkipVerify = b
+			}
+
+		case "mysql_tls_server_name":
+			if current != nil {
+				current.TLSServerName = v
+			} else {
+				mysqlTLSServerName = v
+			}
+
+		case "heartbeat_table":
+			if current != nil {
+				current.HeartbeatTable = v
+			} else {
+				mysqlHeartbeatTable = v
+			}
+
+		case "heartbeat_server_id_column":
+			if current != nil {
+				current.HeartbeatServerIDColumn = v
+			} else {
+				mysqlHeartbeatServerIDColumn = v
+			}
+
+		case "heartbeat_ts_column":
+			if current != nil {
+				current.HeartbeatTSColumn = v
+			} else {
+				mysqlHeartbeatTSColumn = v
+			}
+
+		case "heartbeat_master_server_id":
+			if current != nil {
+				current.HeartbeatMasterServerID = v
+			} else {
+				mysqlHeartbeatMasterServerID = v
+			}
 
 		case "riemann_host":
 			riemannHost = v
@@ -140,6 +477,25 @@ func loadConfig(path string) error {
 		case "tags":
 			riemannTags = strings.Split(v, " ")
 
+		case "outputs":
+			outputs = strings.Split(v, ",")
+			for i := range outputs {
+				outputs[i] = strings.TrimSpace(outputs[i])
+			}
+
+		case "prometheus_listen_addr":
+			prometheusListenAddr = v
+
+		case "graphite_addr":
+			graphiteAddr = v
+
+		case "shutdown_timeout":
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for setting `shutdown_timeout`", v)
+			}
+			shutdownTimeout = d
+
 		default:
 			log.Warn(fmt.Sprintf("unsupported configuration setting %q", k))
 		}
@@ -148,19 +504,41 @@ func loadConfig(path string) error {
 		return err
 	}
 
+	// No `[mysql]` block was configured: fall back to a single target built
+	// from the top-level settings, as before.
+	if len(mysqlTargets) == 0 {
+		mysqlTargets = append(mysqlTargets, &mysqlTarget{
+			Host:          mysqlHost,
+			Port:          mysqlPort,
+			User:          mysqlUser,
+			Password:      mysqlPassword,
+			Database:      mysqlDatabase,
+			TLS:           mysqlTLS,
+			SSLCA:         mysqlSSLCA,
+			SSLCert:       mysqlSSLCert,
+			SSLKey:        mysqlSSLKey,
+			TLSSkipVerify: mysqlTLSSkipVerify,
+			TLSServerName: mysqlTLSServerName,
+
+			HeartbeatTable:          mysqlHeartbeatTable,
+			HeartbeatServerIDColumn: mysqlHeartbeatServerIDColumn,
+			HeartbeatTSColumn:       mysqlHeartbeatTSColumn,
+			HeartbeatMasterServerID: mysqlHeartbeatMasterServerID,
+		})
+	}
+
 	return nil
 }
 
 func main() {
-	var (
-		riemann *raidman.Client
-		db      *mysql.Conn
-		t       *tomb.Tomb
-		err     error
-	)
+	emitters, err := buildEmitters()
+	if err != nil {
+		dieOnError(fmt.Sprintf("unable to initialize outputs: %s", err))
+	}
+
+	t, _ := tomb.WithContext(context.TODO())
 
 	// Handle termination signals
-	t, _ = tomb.WithContext(context.TODO())
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
@@ -169,142 +547,250 @@ func main() {
 		t.Kill(nil)
 	}()
 
-	log.Info("starting")
+	log.Info("starting", "targets", len(mysqlTargets), "outputs", outputs)
 
-	t.Go(func() error {
-		tick := time.NewTicker(interval)
-		for {
-			select {
-			case _ = <-tick.C:
-				log.Debug("getting Riemann server handle")
-				if riemann, err = getRiemannHandle(riemann); err != nil {
-					log.Warn("unable to get Riemann server handle", "error", err)
-					time.Sleep(interval)
-					continue
+	for _, hook := range startupHooks {
+		hook()
+	}
+
+	for _, target := range mysqlTargets {
+		target := target
+		t.Go(func() error {
+			return pollTarget(t, target, emitters)
+		})
+	}
+
+	t.Wait()
+	log.Info("terminating")
+
+	for _, emitter := range emitters {
+		emitter.Close()
+	}
+}
+
+// pollTarget polls a single MySQL target on a ticker until the tomb dies,
+// sending the collected events to every configured emitter after each tick.
+func pollTarget(t *tomb.Tomb, target *mysqlTarget, emitters []Emitter) error {
+	var (
+		db  *mysql.Conn
+		err error
+	)
+
+	servicePrefix := "mysql/replication"
+	statusPrefix := "mysql/status"
+	if len(mysqlTargets) > 1 || target.Label != "" {
+		servicePrefix = fmt.Sprintf("mysql/%s/replication", target.label())
+		statusPrefix = fmt.Sprintf("mysql/%s/status", target.label())
+	}
+
+	tick := time.NewTicker(interval)
+	for {
+		select {
+		case _ = <-tick.C:
+			log.Debug("getting database handle", "target", target.label())
+			if db, err = getDbHandle(db, target); err != nil {
+				log.Warn("unable to get database handle", "target", target.label(), "error", err)
+				select {
+				case <-time.After(interval):
+				case <-t.Dying():
 				}
+				continue
+			}
 
-				log.Debug("getting database handle")
-				if db, err = getDbHandle(db); err != nil {
-					log.Warn("unable to get database handle", "error", err)
-					time.Sleep(interval)
-					continue
+			dying := t.Dying()
+			events := make([]*raidman.Event, 0)
+			t := time.Now()
+
+			log.Debug("gathering statistics", "target", target.label())
+			var (
+				currentGtid string
+				gtidErr     error
+
+				heartbeatLagSeconds float64
+				heartbeatRowFound   bool
+				heartbeatErr        error
+			)
+			r, err := db.Execute("SHOW ALL SLAVES STATUS")
+			if err != nil {
+				log.Warn("unable to query replication status", "target", target.label(), "error", err)
+				events = append(events, &raidman.Event{
+					Time:        t.Unix(),
+					Service:     servicePrefix,
+					State:       "unknown",
+					Description: fmt.Sprintf("unable to query replication status: %s", err),
+					Tags:        riemannTags,
+					Ttl:         float32(interval.Seconds() + delay),
+				})
+				goto send
+			}
+
+			// If
+			// MariaDB [(none)]> show all slaves status;
+			// Empty set (0.000 sec)
+			// we assume is a master
+			if r.Resultset.RowNumber() == 0 {
+				log.Info("There is no replication status, looks like master", "target", target.label())
+				events = append(events, &raidman.Event{
+					Time:        t.Unix(),
+					Service:     servicePrefix + "/master",
+					State:       "ok",
+					Description: "Looks like this is the master",
+					Tags:        riemannTags,
+					Ttl:         float32(interval.Seconds() + delay),
+				})
+				goto send
+			}
+
+			// @@gtid_current_pos is read once per tick and diffed against
+			// each connection's Gtid_IO_Pos below via gtidRelayLogBacklog, which
+			// measures unapplied relay-log backlog rather than true lag behind
+			// the master (see its doc comment).
+			currentGtid, gtidErr = globalVariableValue(db, "gtid_current_pos")
+			if gtidErr != nil {
+				log.Debug("unable to read @@gtid_current_pos", "target", target.label(), "error", gtidErr)
+			}
+
+			if target.HeartbeatTable != "" {
+				heartbeatLagSeconds, heartbeatRowFound, heartbeatErr = heartbeatLag(db, target)
+				if heartbeatErr != nil {
+					log.Warn("unable to query heartbeat table, falling back to Seconds_Behind_Master",
+						"target", target.label(), "table", target.HeartbeatTable, "error", heartbeatErr)
 				}
+			}
 
-				events := make([]*raidman.Event, 0)
-				t := time.Now()
+			for i := 0; i < r.Resultset.RowNumber(); i++ {
+				event := &raidman.Event{
+					Time:    t.Unix(),
+					Service: fmt.Sprintf("%s/conn%d", servicePrefix, i),
+					State:   "ok",
+					Ttl:     float32(interval.Seconds() + delay),
+					Tags:    riemannTags,
+				}
+				if hostname != "" {
+					event.Host = hostname
+				}
 
-				log.Debug("gathering statistics")
-				r, err := db.Execute("SHOW ALL SLAVES STATUS")
+				if connName, _ := r.Resultset.GetStringByName(i, "Connection_name"); connName != "" {
+					event.Service = fmt.Sprintf("%s/%s", servicePrefix, connName)
+				}
+
+				sqlSlaveRunning, err := r.Resultset.GetStringByName(i, "Slave_SQL_Running")
 				if err != nil {
-					log.Warn("unable to query replication status", "error", err)
-					events = append(events, &raidman.Event{
-						Time:        t.Unix(),
-						Service:     "mysql/replication",
-						State:       "unknown",
-						Description: fmt.Sprintf("unable to query replication status: %s", err),
-						Tags:        riemannTags,
-						Ttl:         float32(interval.Seconds() + delay),
-					})
-					goto send
+					event.State = "unknown"
+					event.Description = fmt.Sprintf("unable to retrieve SQL slave state: %s", err)
+					events = append(events, event)
+					log.Warn(event.Description)
+					continue
+				} else if threadState(sqlSlaveRunning) != "running" {
+					event.State = "warning"
 				}
 
-				// If
-				// MariaDB [(none)]> show all slaves status;
-				// Empty set (0.000 sec)
-				// we assume is a master
-				if r.Resultset.RowNumber() == 0 {
-					log.Info("There is no replication status, looks like master")
-					events = append(events, &raidman.Event{
-						Time:        t.Unix(),
-						Service:     "mysql/replication/master",
-						State:       "ok",
-						Description: "Looks like this is the master",
-						Tags:        riemannTags,
-						Ttl:         float32(interval.Seconds() + delay),
-					})
-					goto send
+				ioSlaveRunning, err := r.Resultset.GetStringByName(i, "Slave_IO_Running")
+				if err != nil {
+					event.State = "unknown"
+					event.Description = fmt.Sprintf("unable to retrieve IO thread state: %s", err)
+					events = append(events, event)
+					log.Warn(event.Description)
+					continue
+				} else if threadState(ioSlaveRunning) != "running" {
+					event.State = "critical"
 				}
 
-				for i := 0; i < r.Resultset.RowNumber(); i++ {
-					event := &raidman.Event{
-						Time:    t.Unix(),
-						Service: fmt.Sprintf("mysql/replication/conn%d", i),
-						State:   "ok",
-						Ttl:     float32(interval.Seconds() + delay),
-						Tags:    riemannTags,
-					}
-					if hostname != "" {
-						event.Host = hostname
-					}
+				secondsBehind, err := r.Resultset.GetIntByName(i, "Seconds_Behind_Master")
+				if err != nil {
+					event.State = "unknown"
+					event.Description = fmt.Sprintf("unable to retrieve replication lag value: %s", err)
+					events = append(events, event)
+					log.Warn(event.Description)
+					continue
+				}
 
-					if connName, _ := r.Resultset.GetStringByName(i, "Connection_name"); connName != "" {
-						event.Service = fmt.Sprintf("mysql/replication/%s", connName)
-					}
+				log.Debug("gathered",
+					"target", target.label(),
+					"connection", strings.TrimPrefix(event.Service, servicePrefix+"/"),
+					"sql_thread", threadState(sqlSlaveRunning),
+					"io_thread", threadState(ioSlaveRunning),
+					"seconds_behind", secondsBehind)
 
-					sqlSlaveRunning, err := r.Resultset.GetStringByName(i, "Slave_SQL_Running")
-					if err != nil {
-						event.State = "unknown"
-						event.Description = fmt.Sprintf("unable to retrieve SQL slave state: %s", err)
-						events = append(events, event)
-						log.Warn(event.Description)
-						continue
-					} else if threadState(sqlSlaveRunning) != "running" {
-						event.State = "warning"
-					}
+				event.Description = fmt.Sprintf("slave io: %s, slave sql: %s",
+					threadState(ioSlaveRunning),
+					threadState(sqlSlaveRunning))
+				event.Metric = secondsBehind
 
-					ioSlaveRunning, err := r.Resultset.GetStringByName(i, "Slave_IO_Running")
-					if err != nil {
-						event.State = "unknown"
-						event.Description = fmt.Sprintf("unable to retrieve IO thread state: %s", err)
-						events = append(events, event)
-						log.Warn(event.Description)
-						continue
-					} else if threadState(ioSlaveRunning) != "running" {
-						event.State = "critical"
+				if target.HeartbeatTable != "" && heartbeatErr == nil {
+					if !heartbeatRowFound {
+						// Don't let a missing heartbeat row downgrade a
+						// worse state already derived from the IO/SQL
+						// thread checks above; it's only informative when
+						// nothing more severe was already found.
+						if event.State == "ok" {
+							event.State = "unknown"
+							event.Description = fmt.Sprintf("heartbeat row not found in %s", target.HeartbeatTable)
+						}
+					} else {
+						event.Metric = heartbeatLagSeconds
 					}
+				}
+
+				events = append(events, event)
 
-					secondsBehind, err := r.Resultset.GetIntByName(i, "Seconds_Behind_Master")
-					if err != nil {
-						event.State = "unknown"
-						event.Description = fmt.Sprintf("unable to retrieve replication lag value: %s", err)
-						events = append(events, event)
-						log.Warn(event.Description)
-						continue
+				if gtidErr == nil {
+					if ioPos, _ := r.Resultset.GetStringByName(i, "Gtid_IO_Pos"); ioPos != "" {
+						if backlog, err := gtidRelayLogBacklog(currentGtid, ioPos); err != nil {
+							log.Debug("unable to compute GTID relay-log backlog", "target", target.label(), "connection", i, "error", err)
+						} else {
+							connLabel := strings.TrimPrefix(event.Service, servicePrefix+"/")
+							gtidEvent := newStatusEvent(fmt.Sprintf("%s/%s/gtid_relay_log_backlog", statusPrefix, connLabel), t)
+							gtidEvent.State = thresholdState("gtid_relay_log_backlog", float64(backlog))
+							gtidEvent.Metric = int64(backlog)
+							events = append(events, gtidEvent)
+						}
 					}
+				}
+			}
 
-					log.Debug("gathered",
-						"connection", strings.Split(event.Service, "/")[2],
-						"sql_thread", threadState(sqlSlaveRunning),
-						"io_thread", threadState(ioSlaveRunning),
-						"seconds_behind", secondsBehind)
+		send:
+			events = append(events, collectGlobalStatusEvents(db, target, statusPrefix, t)...)
+			events = append(events, collectInnoDBStatusEvents(db, target, statusPrefix, t)...)
+			events = append(events, collectGlobalVariablesEvents(db, target, statusPrefix, t)...)
 
-					event.Description = fmt.Sprintf("slave io: %s, slave sql: %s",
-						threadState(ioSlaveRunning),
-						threadState(sqlSlaveRunning))
-					event.Metric = secondsBehind
-					events = append(events, event)
-				}
+			log.Debug("sending events", "target", target.label(), "count", len(events))
+
+			// Only the final, lame-duck iteration bounds Emit with a
+			// deadline. Wrapping every tick would leave an abandoned
+			// goroutine blocked on a hung sink (and its lock, for
+			// riemannEmitter) on every timeout, leaking one per tick
+			// forever; a normal tick can afford to wait for its sink.
+			shuttingDown := false
+			select {
+			case <-dying:
+				shuttingDown = true
+			default:
+			}
 
-			send:
-				log.Debug("sending Riemann events")
-				if err := riemann.SendMulti(events); err != nil {
-					log.Error("unable to send Riemann events", "error", err)
+			for _, emitter := range emitters {
+				var err error
+				if shuttingDown {
+					err = emitWithTimeout(emitter, events, shutdownTimeout)
+				} else {
+					err = emitter.Emit(events)
+				}
+				if err != nil {
+					log.Error("unable to emit events", "target", target.label(), "error", err)
 				}
+			}
 
-			case <-t.Dying():
-				return nil
+		case <-t.Dying():
+			// The tick case above always runs to completion (including its
+			// bounded-deadline emits) before this branch can be reached, so
+			// no extra draining is needed here: a lame-duck iteration in
+			// flight already flushed its events by the time we get here.
+			tick.Stop()
+			if db != nil {
+				db.Close()
 			}
+			return nil
 		}
-	})
-
-	t.Wait()
-	log.Info("terminating")
-
-	if db != nil {
-		db.Close()
-	}
-	if riemann != nil {
-		riemann.Close()
 	}
 }
 
@@ -313,7 +799,7 @@ func dieOnError(msg string) {
 	os.Exit(1)
 }
 
-func getDbHandle(db *mysql.Conn) (*mysql.Conn, error) {
+func getDbHandle(db *mysql.Conn, target *mysqlTarget) (*mysql.Conn, error) {
 	if db != nil {
 		if err := db.Ping(); err != nil {
 			return nil, err
@@ -322,19 +808,301 @@ func getDbHandle(db *mysql.Conn) (*mysql.Conn, error) {
 		return db, nil
 	}
 
-	return mysql.Connect(net.JoinHostPort(mysqlHost, mysqlPort), mysqlUser, mysqlPassword, mysqlDatabase)
+	if target.tlsConfig != nil {
+		return mysql.Connect(target.addr(), target.User, target.Password, target.Database,
+			func(c *mysql.Conn) {
+				c.SetTLSConfig(target.tlsConfig)
+			})
+	}
+
+	return mysql.Connect(target.addr(), target.User, target.Password, target.Database)
 }
 
-func getRiemannHandle(riemann *raidman.Client) (*raidman.Client, error) {
-	if riemann != nil {
-		if _, err := riemann.Query(`service =~ "riemann %"`); err != nil {
-			return nil, err
+// buildTLSConfig assembles a *tls.Config from a target's mysql_ssl_*
+// settings. A CA alone is accepted as a server-auth-only setup; a client
+// certificate requires both mysql_ssl_cert and mysql_ssl_key to be set.
+func buildTLSConfig(target *mysqlTarget) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: target.TLSSkipVerify,
+		ServerName:         target.TLSServerName,
+	}
+
+	if target.SSLCA != "" {
+		ca, err := ioutil.ReadFile(target.SSLCA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read `mysql_ssl_ca` file: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("unable to parse `mysql_ssl_ca` file %q", target.SSLCA)
+		}
+		config.RootCAs = pool
+	}
+
+	if (target.SSLCert == "") != (target.SSLKey == "") {
+		return nil, fmt.Errorf("`mysql_ssl_cert` and `mysql_ssl_key` must be set together")
+	}
+
+	if target.SSLCert != "" && target.SSLKey != "" {
+		cert, err := tls.LoadX509KeyPair(target.SSLCert, target.SSLKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate/key pair: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Emitter sends a batch of gathered events to a monitoring backend.
+type Emitter interface {
+	Emit(events []*raidman.Event) error
+	Close()
+}
+
+// emitWithTimeout runs emitter.Emit with a bounded deadline so a stuck sink
+// cannot hang tick processing or delay shutdown indefinitely.
+func emitWithTimeout(emitter Emitter, events []*raidman.Event, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- emitter.Emit(events)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// buildEmitters constructs the Emitter for each configured output.
+func buildEmitters() ([]Emitter, error) {
+	emitters := make([]Emitter, 0, len(outputs))
+	for _, name := range outputs {
+		switch name {
+		case "riemann":
+			emitters = append(emitters, newRiemannEmitter(riemannHost, riemannPort))
+
+		case "prometheus":
+			if prometheusListenAddr == "" {
+				return nil, fmt.Errorf("`prometheus_listen_addr` must be set to use the prometheus output")
+			}
+			promEmitter := newPrometheusEmitter(prometheusListenAddr)
+			onStartup(promEmitter.Start)
+			emitters = append(emitters, promEmitter)
+
+		case "graphite":
+			if graphiteAddr == "" {
+				return nil, fmt.Errorf("`graphite_addr` must be set to use the graphite output")
+			}
+			emitters = append(emitters, newGraphiteEmitter(graphiteAddr))
+
+		default:
+			return nil, fmt.Errorf("unsupported output %q", name)
 		}
+	}
+
+	return emitters, nil
+}
+
+// riemannEmitter sends events to a Riemann server, reconnecting lazily when
+// the connection is found to be down.
+type riemannEmitter struct {
+	addr string
+
+	mu     sync.Mutex
+	client *raidman.Client
+}
 
-		return riemann, nil
+func newRiemannEmitter(host, port string) *riemannEmitter {
+	return &riemannEmitter{addr: net.JoinHostPort(host, port)}
+}
+
+func (e *riemannEmitter) Emit(events []*raidman.Event) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		if _, err := e.client.Query(`service =~ "riemann %"`); err != nil {
+			e.client.Close()
+			e.client = nil
+		}
 	}
 
-	return raidman.Dial("tcp4", net.JoinHostPort(riemannHost, riemannPort))
+	if e.client == nil {
+		client, err := raidman.Dial("tcp4", e.addr)
+		if err != nil {
+			return err
+		}
+		e.client = client
+	}
+
+	return e.client.SendMulti(events)
+}
+
+func (e *riemannEmitter) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		e.client.Close()
+		e.client = nil
+	}
+}
+
+// replicationConnectionRe extracts the target label (empty when there is
+// only one implicit target) and connection name from a
+// mysql/[<target>/]replication/<conn> service.
+var replicationConnectionRe = regexp.MustCompile(`^mysql/(?:([^/]+)/)?replication/([^/]+)$`)
+
+// replicationThreadsRe parses the "slave io: <state>, slave sql: <state>"
+// description set on replication connection events.
+var replicationThreadsRe = regexp.MustCompile(`slave io: (\w+), slave sql: (\w+)`)
+
+// prometheusEmitter exposes the replication connection events as Prometheus
+// gauges on /metrics. It only understands the shape of the events emitted
+// by pollTarget's replication loop; other events (status, InnoDB, GTID lag,
+// ...) are not exported here.
+type prometheusEmitter struct {
+	secondsBehindMaster *prometheus.GaugeVec
+	ioRunning           *prometheus.GaugeVec
+	sqlRunning          *prometheus.GaugeVec
+
+	server *http.Server
+}
+
+func newPrometheusEmitter(listenAddr string) *prometheusEmitter {
+	e := &prometheusEmitter{
+		secondsBehindMaster: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mysql_replication_seconds_behind_master",
+			Help: "Seconds the replica is behind its master, as reported by Seconds_Behind_Master.",
+		}, []string{"target", "connection"}),
+		ioRunning: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mysql_replication_io_running",
+			Help: "Whether the replication IO thread is running (1) or not (0).",
+		}, []string{"target", "connection"}),
+		sqlRunning: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mysql_replication_sql_running",
+			Help: "Whether the replication SQL thread is running (1) or not (0).",
+		}, []string{"target", "connection"}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e.secondsBehindMaster, e.ioRunning, e.sqlRunning)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	e.server = &http.Server{Addr: listenAddr, Handler: mux}
+
+	return e
+}
+
+// Start launches the metrics HTTP server. It is registered as a startup hook
+// rather than run from newPrometheusEmitter so construction stays a pure,
+// side-effect-free step.
+func (e *prometheusEmitter) Start() {
+	go func() {
+		if err := e.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("Prometheus metrics server failed", "error", err)
+		}
+	}()
+}
+
+func (e *prometheusEmitter) Emit(events []*raidman.Event) error {
+	for _, event := range events {
+		m := replicationConnectionRe.FindStringSubmatch(event.Service)
+		if m == nil {
+			continue
+		}
+		target, conn := m[1], m[2]
+
+		if secondsBehind, ok := graphiteValue(event.Metric); ok {
+			e.secondsBehindMaster.WithLabelValues(target, conn).Set(secondsBehind)
+		}
+
+		if threads := replicationThreadsRe.FindStringSubmatch(event.Description); threads != nil {
+			e.ioRunning.WithLabelValues(target, conn).Set(boolToFloat(threads[1] == "running"))
+			e.sqlRunning.WithLabelValues(target, conn).Set(boolToFloat(threads[2] == "running"))
+		}
+	}
+
+	return nil
+}
+
+func (e *prometheusEmitter) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := e.server.Shutdown(ctx); err != nil {
+		log.Warn("unable to shut down Prometheus metrics server cleanly", "error", err)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// graphiteEmitter writes events to a Graphite/InfluxDB line-protocol TCP
+// endpoint, one "<path> <value> <timestamp>" line per numeric event.
+type graphiteEmitter struct {
+	addr string
+}
+
+func newGraphiteEmitter(addr string) *graphiteEmitter {
+	return &graphiteEmitter{addr: addr}
+}
+
+func (e *graphiteEmitter) Emit(events []*raidman.Event) error {
+	var metrics []*raidman.Event
+	for _, event := range events {
+		if _, ok := graphiteValue(event.Metric); ok {
+			metrics = append(metrics, event)
+		}
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", e.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, event := range metrics {
+		value, _ := graphiteValue(event.Metric)
+		path := strings.ReplaceAll(event.Service, "/", ".")
+		if _, err := fmt.Fprintf(conn, "%s %v %d\n", path, value, event.Time); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *graphiteEmitter) Close() {}
+
+// graphiteValue converts a raidman.Event's Metric to a float64, the only
+// value type the Graphite line protocol understands.
+func graphiteValue(metric interface{}) (float64, bool) {
+	switch v := metric.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
 }
 
 func threadState(s string) string {
@@ -344,3 +1112,300 @@ func threadState(s string) string {
 
 	return "stopped"
 }
+
+// newStatusEvent builds a Riemann event for the given service with the
+// fields shared by every status metric.
+func newStatusEvent(service string, now time.Time) *raidman.Event {
+	event := &raidman.Event{
+		Time:    now.Unix(),
+		Service: service,
+		Tags:    riemannTags,
+		Ttl:     float32(interval.Seconds() + delay),
+	}
+	if hostname != "" {
+		event.Host = hostname
+	}
+
+	return event
+}
+
+// globalStatusMetrics are the SHOW GLOBAL STATUS counters reported as-is,
+// one event per entry. Questions is handled separately since it is reported
+// as a derived per-second rate rather than a raw counter.
+var globalStatusMetrics = []string{
+	"Threads_connected",
+	"Threads_running",
+	"Innodb_row_lock_time",
+	"Aborted_connects",
+	"Bytes_sent",
+	"Bytes_received",
+}
+
+// collectGlobalStatusEvents runs SHOW GLOBAL STATUS and emits one event per
+// metric in globalStatusMetrics, plus a derived Questions/sec rate.
+func collectGlobalStatusEvents(db *mysql.Conn, target *mysqlTarget, statusPrefix string, now time.Time) []*raidman.Event {
+	r, err := db.Execute("SHOW GLOBAL STATUS")
+	if err != nil {
+		log.Warn("unable to query global status", "target", target.label(), "error", err)
+		return nil
+	}
+
+	events := make([]*raidman.Event, 0, len(globalStatusMetrics)+1)
+	for _, name := range globalStatusMetrics {
+		value, err := globalStatusValue(r, name)
+		if err != nil {
+			log.Warn("unable to read status variable", "target", target.label(), "variable", name, "error", err)
+			continue
+		}
+
+		metric := strings.ToLower(name)
+		event := newStatusEvent(fmt.Sprintf("%s/%s", statusPrefix, metric), now)
+		event.State = thresholdState(metric, value)
+		event.Metric = value
+		events = append(events, event)
+	}
+
+	questions, err := globalStatusValue(r, "Questions")
+	if err != nil {
+		log.Warn("unable to read status variable", "target", target.label(), "variable", "Questions", "error", err)
+		return events
+	}
+
+	if rate, ok := target.questionsRate(questions, now); ok {
+		event := newStatusEvent(statusPrefix+"/questions_per_sec", now)
+		event.State = thresholdState("questions_per_sec", rate)
+		event.Metric = rate
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// globalStatusValue scans the result of a SHOW GLOBAL STATUS query for the
+// named variable and returns its value as a float64.
+func globalStatusValue(r *gomysql.Result, name string) (float64, error) {
+	for i := 0; i < r.Resultset.RowNumber(); i++ {
+		varName, err := r.Resultset.GetStringByName(i, "Variable_name")
+		if err != nil {
+			return 0, err
+		}
+		if !strings.EqualFold(varName, name) {
+			continue
+		}
+
+		value, err := r.Resultset.GetStringByName(i, "Value")
+		if err != nil {
+			return 0, err
+		}
+
+		return strconv.ParseFloat(value, 64)
+	}
+
+	return 0, fmt.Errorf("variable %q not found", name)
+}
+
+var (
+	innodbHistoryListRe = regexp.MustCompile(`History list length (\d+)`)
+	innodbPendingIORe   = regexp.MustCompile(`Pending normal aio reads: (\d+).*aio writes: (\d+)`)
+)
+
+// collectInnoDBStatusEvents runs SHOW ENGINE INNODB STATUS and extracts the
+// history list length and pending I/O counts from its free-form status
+// text, since InnoDB does not expose them as ordinary status variables.
+func collectInnoDBStatusEvents(db *mysql.Conn, target *mysqlTarget, statusPrefix string, now time.Time) []*raidman.Event {
+	r, err := db.Execute("SHOW ENGINE INNODB STATUS")
+	if err != nil {
+		log.Warn("unable to query InnoDB engine status", "target", target.label(), "error", err)
+		return nil
+	}
+
+	status, err := r.Resultset.GetStringByName(0, "Status")
+	if err != nil {
+		log.Warn("unable to read InnoDB engine status", "target", target.label(), "error", err)
+		return nil
+	}
+
+	var events []*raidman.Event
+
+	if m := innodbHistoryListRe.FindStringSubmatch(status); m != nil {
+		if length, err := strconv.ParseFloat(m[1], 64); err == nil {
+			event := newStatusEvent(statusPrefix+"/innodb_history_list_length", now)
+			event.State = thresholdState("innodb_history_list_length", length)
+			event.Metric = length
+			events = append(events, event)
+		}
+	}
+
+	if m := innodbPendingIORe.FindStringSubmatch(status); m != nil {
+		reads, readsErr := strconv.ParseFloat(m[1], 64)
+		writes, writesErr := strconv.ParseFloat(m[2], 64)
+		if readsErr == nil && writesErr == nil {
+			pending := reads + writes
+			event := newStatusEvent(statusPrefix+"/innodb_pending_ios", now)
+			event.State = thresholdState("innodb_pending_ios", pending)
+			event.Metric = pending
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// globalReadOnlyVariables are the SHOW GLOBAL VARIABLES entries reported as
+// informational 0/1 metrics; being flags rather than counters, they are not
+// subject to warn/crit thresholds.
+var globalReadOnlyVariables = []string{"read_only", "super_read_only"}
+
+// collectGlobalVariablesEvents reports the read_only/super_read_only server
+// flags.
+func collectGlobalVariablesEvents(db *mysql.Conn, target *mysqlTarget, statusPrefix string, now time.Time) []*raidman.Event {
+	r, err := db.Execute(fmt.Sprintf("SHOW GLOBAL VARIABLES WHERE Variable_name IN ('%s')",
+		strings.Join(globalReadOnlyVariables, "','")))
+	if err != nil {
+		log.Warn("unable to query global variables", "target", target.label(), "error", err)
+		return nil
+	}
+
+	events := make([]*raidman.Event, 0, len(globalReadOnlyVariables))
+	for i := 0; i < r.Resultset.RowNumber(); i++ {
+		name, err := r.Resultset.GetStringByName(i, "Variable_name")
+		if err != nil {
+			continue
+		}
+		value, err := r.Resultset.GetStringByName(i, "Value")
+		if err != nil {
+			continue
+		}
+
+		metric := 0.0
+		if strings.EqualFold(value, "ON") {
+			metric = 1.0
+		}
+
+		event := newStatusEvent(fmt.Sprintf("%s/%s", statusPrefix, strings.ToLower(name)), now)
+		event.State = "ok"
+		event.Metric = metric
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// globalVariableValue runs `SELECT @@name` and returns the scalar result as
+// a string.
+func globalVariableValue(db *mysql.Conn, name string) (string, error) {
+	r, err := db.Execute(fmt.Sprintf("SELECT @@%s", name))
+	if err != nil {
+		return "", err
+	}
+
+	return r.Resultset.GetString(0, 0)
+}
+
+// heartbeatLag queries a pt-heartbeat compatible table for the staleness of
+// its heartbeat row, as a sub-second alternative to Seconds_Behind_Master
+// that stays accurate even when the SQL thread is idle. found is false when
+// no row matches the server id filter.
+func heartbeatLag(db *mysql.Conn, target *mysqlTarget) (lag float64, found bool, err error) {
+	serverIDColumn := target.HeartbeatServerIDColumn
+	if serverIDColumn == "" {
+		serverIDColumn = "server_id"
+	}
+
+	tsColumn := target.HeartbeatTSColumn
+	if tsColumn == "" {
+		tsColumn = "ts"
+	}
+
+	filter := "@@server_id"
+	if target.HeartbeatMasterServerID != "" {
+		filter = target.HeartbeatMasterServerID
+	}
+
+	query := fmt.Sprintf("SELECT UNIX_TIMESTAMP(NOW(6)) - UNIX_TIMESTAMP(%s) FROM %s WHERE %s = %s",
+		tsColumn, target.HeartbeatTable, serverIDColumn, filter)
+
+	r, err := db.Execute(query)
+	if err != nil {
+		return 0, false, err
+	}
+	if r.Resultset.RowNumber() == 0 {
+		return 0, false, nil
+	}
+
+	value, err := r.Resultset.GetString(0, 0)
+	if err != nil {
+		return 0, false, err
+	}
+
+	lag, err = strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return lag, true, nil
+}
+
+// gtidParseSeqs parses a MariaDB GTID position string — a comma-separated
+// list of domain-server_id-sequence triplets — into a map of domain to the
+// sequence number observed for that domain.
+func gtidParseSeqs(gtid string) (map[uint32]uint64, error) {
+	seqs := make(map[uint32]uint64)
+	for _, part := range strings.Split(gtid, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fields := strings.SplitN(part, "-", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed GTID %q", part)
+		}
+
+		domain, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed GTID domain in %q: %s", part, err)
+		}
+
+		seq, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed GTID sequence in %q: %s", part, err)
+		}
+
+		seqs[uint32(domain)] = seq
+	}
+
+	return seqs, nil
+}
+
+// gtidRelayLogBacklog returns how far behind the replica's applied GTID
+// position (current) is from what its own IO thread has already received
+// (ioPos), summed across the replication domains common to both. This is
+// the unapplied relay-log backlog, not the replica's distance from the
+// master: if the IO thread itself is stalled or disconnected, ioPos stops
+// advancing too and this reads ~0 even though the replica is falling
+// further behind. Computing true master/replica lag would require a
+// connection to the master's @@gtid_current_pos, which this single-target
+// poll loop does not have.
+func gtidRelayLogBacklog(current, ioPos string) (uint64, error) {
+	currentSeqs, err := gtidParseSeqs(current)
+	if err != nil {
+		return 0, err
+	}
+
+	ioSeqs, err := gtidParseSeqs(ioPos)
+	if err != nil {
+		return 0, err
+	}
+
+	var lag uint64
+	for domain, ioSeq := range ioSeqs {
+		currentSeq, ok := currentSeqs[domain]
+		if !ok || ioSeq <= currentSeq {
+			continue
+		}
+		lag += ioSeq - currentSeq
+	}
+
+	return lag, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import "testing"
+
+func TestGtidParseSeqs(t *testing.T) {
+	cases := []struct {
+		name    string
+		gtid    string
+		want    map[uint32]uint64
+		wantErr bool
+	}{
+		{name: "empty", gtid: "", want: map[uint32]uint64{}},
+		{name: "single domain", gtid: "0-1-100", want: map[uint32]uint64{0: 100}},
+		{name: "multiple domains", gtid: "0-1-100,1-2-200", want: map[uint32]uint64{0: 100, 1: 200}},
+		{name: "whitespace around parts", gtid: "0-1-100, 1-2-200", want: map[uint32]uint64{0: 100, 1: 200}},
+		{name: "malformed, missing fields", gtid: "0-1", wantErr: true},
+		{name: "malformed domain", gtid: "x-1-100", wantErr: true},
+		{name: "malformed sequence", gtid: "0-1-x", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := gtidParseSeqs(tc.gtid)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("gtidParseSeqs(%q): expected error, got none", tc.gtid)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("gtidParseSeqs(%q): unexpected error: %s", tc.gtid, err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("gtidParseSeqs(%q) = %v, want %v", tc.gtid, got, tc.want)
+			}
+			for domain, seq := range tc.want {
+				if got[domain] != seq {
+					t.Fatalf("gtidParseSeqs(%q) = %v, want %v", tc.gtid, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGtidRelayLogBacklog(t *testing.T) {
+	cases := []struct {
+		name    string
+		current string
+		ioPos   string
+		want    uint64
+		wantErr bool
+	}{
+		{name: "fully applied", current: "0-1-100", ioPos: "0-1-100", want: 0},
+		{name: "backlog in one domain", current: "0-1-100", ioPos: "0-1-150", want: 50},
+		{name: "backlog across domains", current: "0-1-100,1-2-200", ioPos: "0-1-150,1-2-250", want: 100},
+		{name: "domain only on io side is ignored", current: "0-1-100", ioPos: "0-1-100,2-3-999", want: 0},
+		{name: "io behind current is not negative backlog", current: "0-1-150", ioPos: "0-1-100", want: 0},
+		{name: "malformed current", current: "bogus", ioPos: "0-1-100", wantErr: true},
+		{name: "malformed io position", current: "0-1-100", ioPos: "bogus", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := gtidRelayLogBacklog(tc.current, tc.ioPos)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("gtidRelayLogBacklog(%q, %q): expected error, got none", tc.current, tc.ioPos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("gtidRelayLogBacklog(%q, %q): unexpected error: %s", tc.current, tc.ioPos, err)
+			}
+			if got != tc.want {
+				t.Fatalf("gtidRelayLogBacklog(%q, %q) = %d, want %d", tc.current, tc.ioPos, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetThresholdAndThresholdState(t *testing.T) {
+	saved := statusThresholds
+	defer func() { statusThresholds = saved }()
+
+	cases := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{name: "warn", key: "threshold_gtid_relay_log_backlog_warn", value: "10"},
+		{name: "crit", key: "threshold_gtid_relay_log_backlog_crit", value: "100"},
+		{name: "malformed suffix", key: "threshold_gtid_relay_log_backlog", value: "10", wantErr: true},
+		{name: "malformed value", key: "threshold_gtid_relay_log_backlog_warn", value: "not-a-number", wantErr: true},
+	}
+
+	statusThresholds = map[string]*threshold{}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := setThreshold(tc.key, tc.value)
+			if tc.wantErr && err == nil {
+				t.Fatalf("setThreshold(%q, %q): expected error, got none", tc.key, tc.value)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("setThreshold(%q, %q): unexpected error: %s", tc.key, tc.value, err)
+			}
+		})
+	}
+
+	stateCases := []struct {
+		name   string
+		metric string
+		value  float64
+		want   string
+	}{
+		{name: "below warn", metric: "gtid_relay_log_backlog", value: 5, want: "ok"},
+		{name: "at warn", metric: "gtid_relay_log_backlog", value: 10, want: "warning"},
+		{name: "at crit", metric: "gtid_relay_log_backlog", value: 100, want: "critical"},
+		{name: "unconfigured metric defaults to ok", metric: "unconfigured_metric", value: 1e9, want: "ok"},
+	}
+
+	for _, tc := range stateCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := thresholdState(tc.metric, tc.value); got != tc.want {
+				t.Fatalf("thresholdState(%q, %v) = %q, want %q", tc.metric, tc.value, got, tc.want)
+			}
+		})
+	}
+}